@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/vektra/mockery/mockery"
@@ -15,6 +17,16 @@ import (
 var usageFmt = `%s - Generate mocks for all or some of a package's exported functions.
 
 Usage: %s [options] <package> [<func1> <func2> ...]
+
+<package> may be a directory path, an import path, or a "..." pattern
+matching multiple packages (e.g. "./..." or "github.com/foo/...").
+Matched packages with nothing to mock are skipped. A pattern that yields
+mocks for more than one package requires -outdir, which writes one mock
+file per package; there is no single-file output for multiple packages,
+since each package's mock needs its own "package" clause.
+
+With -type, <func1> <func2> ... restrict which methods of that type are
+mocked instead of restricting free functions.
 `
 
 func usage() {
@@ -23,11 +35,29 @@ func usage() {
 	os.Exit(1)
 }
 
+func generatorForStyle(style string) mockpkg.Generator {
+	switch style {
+	case "", "mockery":
+		return mockpkg.MockeryGenerator{}
+	case "moq":
+		return mockpkg.MoqGenerator{}
+	default:
+		log.Fatalf("unknown -style %q (want mockery or moq)", style)
+		return nil
+	}
+}
+
 func main() {
 	var (
 		outFile   = flag.String("outfile", "", "file to write mocks to; if empty output to stdout")
-		overwrite = flag.Bool("overwrite", false, "overwrite the destination file if it exists")
+		outDir    = flag.String("outdir", "", "directory to write one mock file per package to; overrides -outfile")
+		overwrite = flag.Bool("overwrite", false, "overwrite destination file(s) if they exist")
 		buildTags = flag.String("tags", "", "space-separated list of additional build tags to use")
+		typeName  = flag.String("type", "", "mock the exported methods of this type instead of the package's free functions")
+		style     = flag.String("style", "mockery", "mock generator backend to use: mockery or moq")
+		goos      = flag.String("goos", "", "GOOS to resolve the package for; defaults to the host's")
+		goarch    = flag.String("goarch", "", "GOARCH to resolve the package for; defaults to the host's")
+		cgo       = flag.Bool("cgo", true, "allow resolving packages that import \"C\"")
 	)
 	flag.Parse()
 
@@ -35,6 +65,51 @@ func main() {
 		usage()
 	}
 
+	pattern := flag.Arg(0)
+	funcs := flag.Args()[1:]
+
+	var pars *mockpkg.Parser
+	if *typeName != "" {
+		pars = mockpkg.NewParserForType(pattern, *typeName, funcs)
+	} else {
+		pars = mockpkg.NewParser(pattern, funcs)
+	}
+	if *buildTags != "" {
+		pars.AddBuildTags(strings.Split(*buildTags, " ")...)
+	}
+	if *goos != "" {
+		pars.SetGOOS(*goos)
+	}
+	if *goarch != "" {
+		pars.SetGOARCH(*goarch)
+	}
+	pars.CGOEnabled(*cgo)
+
+	if err := pars.Parse(); err != nil {
+		log.Fatalf("parse error: %v", err)
+	}
+	if err := pars.Load(); err != nil {
+		log.Fatalf("load error: %v", err)
+	}
+
+	ifaces, err := pars.Interfaces()
+	if err != nil {
+		log.Fatalf("iface error: %v", err)
+	}
+
+	gen := generatorForStyle(*style)
+
+	if *outDir != "" {
+		if err := writeMocksToDir(gen, ifaces, *outDir, *overwrite); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(ifaces) > 1 {
+		log.Fatalf("pattern %q yielded mocks for %d packages; use -outdir to write one mock file per package", pattern, len(ifaces))
+	}
+
 	out := os.Stdout
 	if *outFile != "" {
 		_, err := os.Stat(*outFile)
@@ -48,35 +123,62 @@ func main() {
 		out = f
 	}
 
-	path := flag.Arg(0)
-	funcs := flag.Args()[1:]
-
-	pars := mockpkg.NewParser(path, funcs)
-	if *buildTags != "" {
-		pars.AddBuildTags(strings.Split(*buildTags, " ")...)
+	if err := generate(gen, ifaces[0], out); err != nil {
+		log.Fatalf("%s: %v", ifaces[0].Pkg.Path(), err)
 	}
+}
 
-	if err := pars.Parse(); err != nil {
-		log.Fatalf("parse error: %v", err)
-	}
-	if err := pars.Load(); err != nil {
-		log.Fatalf("load error: %v", err)
+// writeMocksToDir writes one mock file per interface into dir, named after
+// the interface's full import path so that two packages sharing a
+// directory basename (e.g. two unrelated ".../util" packages) don't
+// collide.
+func writeMocksToDir(gen mockpkg.Generator, ifaces []*mockery.Interface, dir string, overwrite bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create outdir: %w", err)
 	}
 
-	iface, err := pars.Interface()
-	if err != nil {
-		log.Fatalf("iface error: %v", err)
+	for _, iface := range ifaces {
+		fname := filepath.Join(dir, mockFilename(iface.Pkg.Path()))
+
+		_, err := os.Stat(fname)
+		if !os.IsNotExist(err) && !overwrite {
+			return fmt.Errorf("%s: output file exists; use -overwrite to overwrite", fname)
+		}
+		f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open output file %s: %w", fname, err)
+		}
+
+		err = generate(gen, iface, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", iface.Pkg.Path(), err)
+		}
 	}
 
-	pkg := iface.Pkg.Path()
-	gen := mockery.NewGenerator(iface, pkg, false, "")
-	gen.GeneratePrologueNote("")
-	gen.GeneratePrologue("mocks")
-	if err := gen.Generate(); err != nil {
-		log.Fatalf("generate error: %v", err)
+	return nil
+}
+
+// mockFilename turns an import path into a filesystem-safe mock filename,
+// replacing every character that isn't a letter, digit, or underscore with
+// an underscore.
+func mockFilename(importPath string) string {
+	var b strings.Builder
+	for _, r := range importPath {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
 	}
+	b.WriteString("_mock.go")
+	return b.String()
+}
 
-	if err := gen.Write(out); err != nil {
-		log.Fatalf("write error: %v", err)
+func generate(gen mockpkg.Generator, iface *mockery.Interface, out io.Writer) error {
+	if err := gen.Generate(iface, iface.Pkg.Path(), out); err != nil {
+		return fmt.Errorf("generate error: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,27 @@
+package mockpkg
+
+import (
+	"io"
+
+	"github.com/vektra/mockery/mockery"
+)
+
+// Generator renders a mock implementation of iface, as if it belonged to
+// package pkg, to w.
+type Generator interface {
+	Generate(iface *mockery.Interface, pkg string, w io.Writer) error
+}
+
+// MockeryGenerator renders mocks using vektra/mockery's testify-based
+// generator; it's the backend mockpkg has always used.
+type MockeryGenerator struct{}
+
+func (MockeryGenerator) Generate(iface *mockery.Interface, pkg string, w io.Writer) error {
+	gen := mockery.NewGenerator(iface, pkg, false, "")
+	gen.GeneratePrologueNote("")
+	gen.GeneratePrologue("mocks")
+	if err := gen.Generate(); err != nil {
+		return err
+	}
+	return gen.Write(w)
+}
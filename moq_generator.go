@@ -0,0 +1,210 @@
+package mockpkg
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/vektra/mockery/mockery"
+)
+
+// MoqGenerator renders mocks in the style of matryer/moq: a struct with one
+// settable *Func field per method, plus call-recording slices protected by a
+// sync.RWMutex, rather than mockery's testify-based struct.
+type MoqGenerator struct{}
+
+func (MoqGenerator) Generate(iface *mockery.Interface, pkg string, w io.Writer) error {
+	ifaceType := iface.Type
+
+	// pkg is the full import path (e.g. "github.com/foo/bar"); the package
+	// clause we render needs the short package name instead.
+	data, err := newMoqData(iface.Pkg.Name(), iface.Name, ifaceType, iface.Pkg)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := moqTemplate.Execute(&buf, data); err != nil {
+		return fmt.Errorf("executing moq template: %w", err)
+	}
+
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting generated mock: %w", err)
+	}
+
+	_, err = w.Write(formatted)
+	return err
+}
+
+type moqParamData struct {
+	Name     string
+	ArgField string
+	Type     string
+}
+
+type moqMethodData struct {
+	Name       string
+	Params     []moqParamData
+	ParamSig   string
+	ParamNames string
+	ResultSig  string
+	HasResults bool
+}
+
+type moqTemplateData struct {
+	Package   string
+	IfaceName string
+	MockName  string
+	Imports   []string
+	Methods   []moqMethodData
+}
+
+// newMoqData walks ifaceType's methods, collecting per-method parameter and
+// result information and the set of imports their types require.
+func newMoqData(pkg, ifaceName string, ifaceType *types.Interface, localPkg *types.Package) (moqTemplateData, error) {
+	imports := map[string]bool{"sync": true}
+	qual := func(p *types.Package) string {
+		if p == localPkg {
+			return ""
+		}
+		imports[p.Path()] = true
+		return p.Name()
+	}
+
+	data := moqTemplateData{
+		Package:   pkg,
+		IfaceName: ifaceName,
+		MockName:  ifaceName + "Mock",
+	}
+
+	for i := 0; i < ifaceType.NumMethods(); i++ {
+		fn := ifaceType.Method(i)
+		sig, ok := fn.Type().(*types.Signature)
+		if !ok {
+			return moqTemplateData{}, fmt.Errorf("method %s has non-signature type", fn.Name())
+		}
+
+		md, err := newMoqMethodData(fn.Name(), sig, qual)
+		if err != nil {
+			return moqTemplateData{}, err
+		}
+		data.Methods = append(data.Methods, md)
+	}
+
+	for path := range imports {
+		data.Imports = append(data.Imports, path)
+	}
+	sort.Strings(data.Imports)
+
+	return data, nil
+}
+
+func newMoqMethodData(name string, sig *types.Signature, qual types.Qualifier) (moqMethodData, error) {
+	md := moqMethodData{Name: name}
+
+	var sigParts, callArgs []string
+	params := sig.Params()
+	for j := 0; j < params.Len(); j++ {
+		v := params.At(j)
+		pname := v.Name()
+		if pname == "" || pname == "_" {
+			pname = fmt.Sprintf("in%d", j+1)
+		}
+
+		typeStr := types.TypeString(v.Type(), qual)
+		sigType := typeStr
+		callArg := pname
+		if sig.Variadic() && j == params.Len()-1 {
+			elem := v.Type().(*types.Slice).Elem()
+			sigType = "..." + types.TypeString(elem, qual)
+			callArg = pname + "..."
+		}
+
+		sigParts = append(sigParts, pname+" "+sigType)
+		callArgs = append(callArgs, callArg)
+
+		md.Params = append(md.Params, moqParamData{
+			Name:     pname,
+			ArgField: strings.ToUpper(pname[0:1]) + pname[1:],
+			Type:     typeStr,
+		})
+	}
+	md.ParamSig = strings.Join(sigParts, ", ")
+	md.ParamNames = strings.Join(callArgs, ", ")
+
+	results := sig.Results()
+	var resultParts []string
+	for j := 0; j < results.Len(); j++ {
+		r := results.At(j)
+		s := types.TypeString(r.Type(), qual)
+		if r.Name() != "" {
+			s = r.Name() + " " + s
+		}
+		resultParts = append(resultParts, s)
+	}
+	md.HasResults = results.Len() > 0
+	switch {
+	case results.Len() == 0:
+		md.ResultSig = ""
+	case results.Len() == 1 && results.At(0).Name() == "":
+		md.ResultSig = resultParts[0]
+	default:
+		md.ResultSig = "(" + strings.Join(resultParts, ", ") + ")"
+	}
+
+	return md, nil
+}
+
+var moqTemplate = template.Must(template.New("moq").Parse(`// Code generated by mockpkg (moq style). DO NOT EDIT.
+
+package {{.Package}}
+{{if .Imports}}
+import (
+{{range .Imports}}	"{{.}}"
+{{end}})
+{{end}}
+// {{.MockName}} is a moq-style mock of {{.IfaceName}}: assign the *Func
+// fields to control behavior, then inspect the *Calls methods to assert on
+// usage.
+type {{.MockName}} struct {
+{{range .Methods}}	// {{.Name}}Func mocks the {{.Name}} method.
+	{{.Name}}Func func({{.ParamSig}}) {{.ResultSig}}
+{{end}}
+	calls struct {
+{{range .Methods}}		// {{.Name}} holds details about calls to the {{.Name}} method.
+		{{.Name}} []{{template "argStruct" .}}
+{{end}}	}
+{{range .Methods}}	lock{{.Name}} sync.RWMutex
+{{end}}}
+{{range .Methods}}
+func (mock *{{$.MockName}}) {{.Name}}({{.ParamSig}}) {{.ResultSig}} {
+	if mock.{{.Name}}Func == nil {
+		panic("{{$.MockName}}.{{.Name}}Func: method is nil but {{$.IfaceName}}.{{.Name}} was called")
+	}
+	mock.lock{{.Name}}.Lock()
+	mock.calls.{{.Name}} = append(mock.calls.{{.Name}}, {{template "argStruct" .}}{
+{{range .Params}}		{{.ArgField}}: {{.Name}},
+{{end}}	})
+	mock.lock{{.Name}}.Unlock()
+	{{if .HasResults}}return {{end}}mock.{{.Name}}Func({{.ParamNames}})
+}
+
+// {{.Name}}Calls returns the recorded calls to {{.Name}}.
+func (mock *{{$.MockName}}) {{.Name}}Calls() []{{template "argStruct" .}} {
+	mock.lock{{.Name}}.RLock()
+	defer mock.lock{{.Name}}.RUnlock()
+	calls := make([]{{template "argStruct" .}}, len(mock.calls.{{.Name}}))
+	copy(calls, mock.calls.{{.Name}})
+	return calls
+}
+{{end}}
+{{define "argStruct"}}struct {
+{{range .Params}}		{{.ArgField}} {{.Type}}
+{{end}}	}{{end}}
+`))
@@ -2,134 +2,129 @@ package mockpkg
 
 import (
 	"errors"
+	"fmt"
 	"go/ast"
-	"go/build"
-	"go/importer"
 	"go/token"
 	"go/types"
-	"io/ioutil"
 	"log"
 	"os"
-	"path"
-	"path/filepath"
 	"sort"
 	"strings"
-	"sync"
 
 	"github.com/vektra/mockery/mockery"
-	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/packages"
 )
 
 // This is largely borrowed from mockery, tweaked to collect functions rather
 // than interfaces and deal with both import paths and directory paths.
 
+// loadMode is the set of packages.Load information we need: enough to
+// resolve imports and type-check the package's syntax trees.
+const loadMode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+	packages.NeedImports | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo
+
 type Parser struct {
-	configMapping  map[string][]*ast.File
-	pathToFuncs    map[string][]string
-	pathToASTFile  map[string]*ast.File
-	parserPackages []*types.Package
-	conf           loader.Config
-	pkgPath        string
-	pkgName        string
-	desiredFuncs   []string
+	cfg          *packages.Config
+	pkgs         []*packages.Package
+	pathToFuncs  map[string][]string
+	pkgPath      string
+	desiredFuncs []string
+	typeName     string
 }
 
 func NewParser(pkgPath string, funcs []string) *Parser {
-	var conf loader.Config
-
-	conf.TypeCheckFuncBodies = func(_ string) bool { return false }
-	conf.TypeChecker.DisableUnusedImportCheck = true
-	conf.TypeChecker.Importer = importer.Default()
-
-	// Initialize the build context (e.g. GOARCH/GOOS fields) so we can use it for respecting
-	// build tags during Parse.
-	buildCtx := build.Default
-	conf.Build = &buildCtx
-
 	return &Parser{
-		parserPackages: make([]*types.Package, 0),
-		configMapping:  make(map[string][]*ast.File),
-		pathToFuncs:    make(map[string][]string),
-		pathToASTFile:  make(map[string]*ast.File),
-		conf:           conf,
-		pkgPath:        pkgPath,
-		pkgName:        filepath.Base(pkgPath),
-		desiredFuncs:   funcs,
+		cfg: &packages.Config{
+			Mode: loadMode,
+			Fset: token.NewFileSet(),
+		},
+		pathToFuncs:  make(map[string][]string),
+		pkgPath:      pkgPath,
+		desiredFuncs: funcs,
 	}
 }
 
+// NewParserForType returns a Parser that mocks the exported methods of
+// typeName (value or pointer receiver) rather than a package's free
+// functions, letting callers mock a concrete struct's public API without
+// hand-writing an interface for it. As with NewParser, an empty methods
+// slice means "all exported methods".
+func NewParserForType(pkgPath, typeName string, methods []string) *Parser {
+	p := NewParser(pkgPath, methods)
+	p.typeName = typeName
+	return p
+}
+
 func (p *Parser) AddBuildTags(buildTags ...string) {
-	p.conf.Build.BuildTags = append(p.conf.Build.BuildTags, buildTags...)
+	p.cfg.BuildFlags = append(p.cfg.BuildFlags, "-tags="+strings.Join(buildTags, ","))
 }
 
-func (p *Parser) Parse() error {
-	pkgPath := p.pkgPath
-
-	// If not using an absolute path, see if it's relative or an import path.
-	if !path.IsAbs(pkgPath) {
-		st, err := os.Stat(pkgPath)
-		if err != nil || !st.IsDir() {
-			pkg, err := p.conf.Build.Import(pkgPath, "", 0)
-			if err != nil {
-				return err
-			}
-			pkgPath = pkg.Dir
-		}
+// SetGOOS overrides GOOS for the underlying `go list` invocation that
+// packages.Load uses to resolve the package, so files gated by filename
+// suffix, a //go:build line, or a legacy "// +build" comment are evaluated
+// for that target platform rather than the host's.
+func (p *Parser) SetGOOS(goos string) {
+	p.setEnv("GOOS", goos)
+}
+
+// SetGOARCH overrides GOARCH for the underlying `go list` invocation; see
+// SetGOOS.
+func (p *Parser) SetGOARCH(goarch string) {
+	p.setEnv("GOARCH", goarch)
+}
+
+// CGOEnabled toggles cgo support for the underlying `go list` invocation.
+// With it enabled, packages that `import "C"` are resolved (cgo
+// preprocessing, including constant and type information from the C code,
+// is handled by `go list` itself). With it disabled, files that import "C"
+// are excluded from their package, as `go build` excludes them when cgo is
+// off; a package that then fails to load or type-check makes Parse return
+// that error.
+func (p *Parser) CGOEnabled(enabled bool) {
+	val := "0"
+	if enabled {
+		val = "1"
 	}
+	p.setEnv("CGO_ENABLED", val)
+}
 
-	// To support relative paths to mock targets w/ vendor deps, we need to provide eventual
-	// calls to build.Context.Import with an absolute path. It needs to be absolute because
-	// Import will only find the vendor directory if our target path for parsing is under
-	// a "root" (GOROOT or a GOPATH). Only absolute paths will pass the prefix-based validation.
-	//
-	// For example, if our parse target is "./ifaces", Import will check if any "roots" are a
-	// prefix of "ifaces" and decide to skip the vendor search.
-	pkgPath, err := filepath.Abs(pkgPath)
-	if err != nil {
-		return err
+func (p *Parser) setEnv(key, value string) {
+	if p.cfg.Env == nil {
+		p.cfg.Env = os.Environ()
 	}
 
-	pkgPath, err = filepath.EvalSymlinks(pkgPath)
-	if err != nil {
-		return err
+	prefix := key + "="
+	for i, kv := range p.cfg.Env {
+		if strings.HasPrefix(kv, prefix) {
+			p.cfg.Env[i] = prefix + value
+			return
+		}
 	}
+	p.cfg.Env = append(p.cfg.Env, prefix+value)
+}
 
-	dir := pkgPath
-	files, err := ioutil.ReadDir(pkgPath)
+// Parse loads the configured package (and, by virtue of go/packages, its
+// module metadata: go.mod replace directives and any vendor/ directory are
+// honored automatically). Both directory paths and import paths like
+// "github.com/foo/bar" are accepted. Build constraints, whether expressed
+// as a //go:build line, a legacy "// +build" comment, or a filename suffix,
+// are evaluated by the underlying `go list` call against the configured
+// GOOS, GOARCH, and build tags; cgo files are handled the same way.
+func (p *Parser) Parse() error {
+	pkgs, err := packages.Load(p.cfg, p.pkgPath)
 	if err != nil {
 		return err
 	}
-
-	for _, fi := range files {
-		if filepath.Ext(fi.Name()) != ".go" || strings.HasSuffix(fi.Name(), "_test.go") {
-			continue
-		}
-
-		fname := fi.Name()
-		fpath := filepath.Join(dir, fname)
-
-		// If go/build would ignore this file, e.g. based on build tags, also ignore it here.
-		//
-		// (Further coupling with go internals and x/tools may of course bear a cost eventually
-		// e.g. https://github.com/vektra/mockery/pull/117#issue-199337071, but should add
-		// worthwhile consistency in this tool's behavior in the meantime.)
-		match, matchErr := p.conf.Build.MatchFile(dir, fname)
-		if matchErr != nil {
-			return matchErr
-		}
-		if !match {
-			continue
-		}
-
-		f, parseErr := p.conf.ParseFile(fpath, nil)
-		if parseErr != nil {
-			return parseErr
+	if len(pkgs) == 0 {
+		return errors.New("no packages found")
+	}
+	for _, pkg := range pkgs {
+		for _, packageErr := range pkg.Errors {
+			return packageErr
 		}
-
-		p.configMapping[pkgPath] = append(p.configMapping[pkgPath], f)
-		p.pathToASTFile[fpath] = f
 	}
 
+	p.pkgs = pkgs
 	return nil
 }
 
@@ -157,68 +152,147 @@ func (nv *NodeVisitor) Visit(node ast.Node) ast.Visitor {
 	return nv
 }
 
+// Load walks the syntax trees gathered by Parse to find the exported,
+// free-standing functions declared in each file.
 func (p *Parser) Load() error {
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		for path, fi := range p.pathToASTFile {
+	for _, pkg := range p.pkgs {
+		for _, file := range pkg.Syntax {
 			nv := NewNodeVisitor()
-			ast.Walk(nv, fi)
-			p.pathToFuncs[path] = nv.DeclaredFuncs()
+			ast.Walk(nv, file)
+
+			fname := p.cfg.Fset.Position(file.Pos()).Filename
+			p.pathToFuncs[fname] = nv.DeclaredFuncs()
 		}
-		wg.Done()
-	}()
+	}
 
-	// Type-check a package consisting of this file.
-	// Type information for the imported packages
-	// comes from $GOROOT/pkg/$GOOS_$GOOARCH/fmt.a.
-	for path, files := range p.configMapping {
-		p.conf.CreateFromFiles(path, files...)
+	return nil
+}
+
+// Interface builds the mockery.Interface for the single package matched by
+// Parse. It returns an error if the configured pattern matched more than one
+// package; use Interfaces for that case.
+func (p *Parser) Interface() (*mockery.Interface, error) {
+	if len(p.pkgs) != 1 {
+		return nil, errors.New("too many packages")
 	}
+	return p.ifaceForPackage(p.pkgs[0])
+}
 
-	prog, err := p.conf.Load()
-	if err != nil {
-		return err
+// Interfaces builds one mockery.Interface per package matched by Parse,
+// allowing patterns such as "./..." or "github.com/foo/..." to produce a
+// mock for every package they expand to. A "..." pattern is expected to
+// match packages that have nothing to mock (no exported free functions, or
+// no declaration of the requested -type); those packages are skipped rather
+// than failing the whole call. Any other error from a package fails the
+// whole call, and so does every matched package being skipped.
+func (p *Parser) Interfaces() ([]*mockery.Interface, error) {
+	if len(p.pkgs) == 0 {
+		return nil, errors.New("no packages to build interfaces from")
 	}
 
-	for _, pkgInfo := range prog.Created {
-		p.parserPackages = append(p.parserPackages, pkgInfo.Pkg)
+	ifaces := make([]*mockery.Interface, 0, len(p.pkgs))
+	for _, pkg := range p.pkgs {
+		iface, err := p.ifaceForPackage(pkg)
+		if err != nil {
+			if errors.Is(err, errTypeNotFound) || errors.Is(err, errNoFuncsForInterface) {
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", pkg.PkgPath, err)
+		}
+		ifaces = append(ifaces, iface)
+	}
+	if len(ifaces) == 0 {
+		return nil, fmt.Errorf("no package matched by %q yielded an interface", p.pkgPath)
 	}
 
-	wg.Wait()
-	return nil
+	return ifaces, nil
 }
 
-func (p *Parser) Interface() (*mockery.Interface, error) {
-	if len(p.parserPackages) != 1 {
-		return nil, errors.New("too many packages")
+func (p *Parser) ifaceForPackage(pkg *packages.Package) (*mockery.Interface, error) {
+	if p.typeName != "" {
+		return p.ifaceForType(pkg)
 	}
-	pkg := p.parserPackages[0]
 
 	iface := &mockery.Interface{
-		Name: strings.ToUpper(p.pkgName[0:1]) + p.pkgName[1:],
-		Pkg:  p.parserPackages[0],
+		Name: strings.ToUpper(pkg.Name[0:1]) + pkg.Name[1:],
+		Pkg:  pkg.Types,
+	}
+
+	sort.Strings(p.desiredFuncs)
+
+	var funcs []*types.Func
+	for _, file := range pkg.Syntax {
+		fname := p.cfg.Fset.Position(file.Pos()).Filename
+		funcs = append(funcs, p.fileFuncs(pkg.Types, p.pathToFuncs[fname])...)
+	}
+	if len(funcs) == 0 {
+		return nil, errNoFuncsForInterface
+	}
+
+	iface.Type = types.NewInterface(funcs, nil).Complete()
+	typeName := types.NewTypeName(token.NoPos, pkg.Types, iface.Name, iface.Type)
+	iface.NamedType = types.NewNamed(typeName, iface.Type, funcs)
+
+	return iface, nil
+}
+
+// errNoFuncsForInterface is returned by ifaceForPackage when pkg has no
+// exported free functions (optionally matching p.desiredFuncs) to mock, so
+// Interfaces can skip the package instead of failing outright.
+var errNoFuncsForInterface = errors.New("no functions for interface")
+
+// errTypeNotFound is wrapped by ifaceForType when pkg doesn't declare the
+// requested type, so Interfaces can tell that case apart from a real error
+// and skip the package instead of failing outright.
+var errTypeNotFound = errors.New("type not found")
+
+// ifaceForType synthesizes a mockery.Interface from the exported methods of
+// p.typeName, as found in pkg's method set (both value and pointer
+// receivers). p.desiredFuncs, if non-empty, restricts the result to methods
+// with those names.
+func (p *Parser) ifaceForType(pkg *packages.Package) (*mockery.Interface, error) {
+	obj := pkg.Types.Scope().Lookup(p.typeName)
+	if obj == nil {
+		return nil, fmt.Errorf("%w: %s not found in package %s", errTypeNotFound, p.typeName, pkg.PkgPath)
+	}
+	tn, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a type", p.typeName)
 	}
 
 	sort.Strings(p.desiredFuncs)
 
 	var funcs []*types.Func
-	for file, names := range p.pathToFuncs {
-		ast := p.pathToASTFile[file]
-		funcs = append(funcs, p.fileFuncs(pkg, ast, names)...)
+	mset := types.NewMethodSet(types.NewPointer(tn.Type()))
+	for i := 0; i < mset.Len(); i++ {
+		fn, ok := mset.At(i).Obj().(*types.Func)
+		if !ok || !ast.IsExported(fn.Name()) {
+			continue
+		}
+		if len(p.desiredFuncs) > 0 {
+			idx := sort.SearchStrings(p.desiredFuncs, fn.Name())
+			if idx >= len(p.desiredFuncs) || p.desiredFuncs[idx] != fn.Name() {
+				continue
+			}
+		}
+		funcs = append(funcs, fn)
 	}
 	if len(funcs) == 0 {
-		return nil, errors.New("no functions for interface")
+		return nil, errors.New("no methods for interface")
 	}
 
+	iface := &mockery.Interface{
+		Name: p.typeName,
+		Pkg:  pkg.Types,
+	}
 	iface.Type = types.NewInterface(funcs, nil).Complete()
-	typeName := types.NewTypeName(token.NoPos, pkg, iface.Name, iface.Type)
+	typeName := types.NewTypeName(token.NoPos, pkg.Types, iface.Name, iface.Type)
 	iface.NamedType = types.NewNamed(typeName, iface.Type, funcs)
 
 	return iface, nil
 }
 
-func (p *Parser) fileFuncs(pkg *types.Package, ast *ast.File, names []string) []*types.Func {
+func (p *Parser) fileFuncs(pkg *types.Package, names []string) []*types.Func {
 	scope := pkg.Scope()
 	var funcs []*types.Func
 	for _, name := range names {